@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// OriginAllowList enforces a configurable allow-list of WebSocket origins,
+// replacing an unconditional CheckOrigin. Entries are exact matches, or a
+// wildcard suffix like "*.example.com".
+type OriginAllowList struct {
+	origins []string
+}
+
+// newOriginAllowList builds an OriginAllowList from a comma-separated list
+// of origins.
+func newOriginAllowList(csv string) *OriginAllowList {
+	list := &OriginAllowList{}
+	for _, origin := range strings.Split(csv, ",") {
+		origin = strings.TrimSpace(origin)
+		if origin != "" {
+			list.origins = append(list.origins, origin)
+		}
+	}
+	return list
+}
+
+// Allowed reports whether origin matches one of the configured entries.
+func (a *OriginAllowList) Allowed(origin string) bool {
+	for _, allowed := range a.origins {
+		if suffix, ok := strings.CutPrefix(allowed, "*"); ok {
+			if strings.HasSuffix(origin, suffix) {
+				return true
+			}
+			continue
+		}
+		if origin == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// TokenVerifier checks a bearer token presented by a connecting client and
+// confirms it authorizes the requested username.
+type TokenVerifier interface {
+	// Verify returns an error if tokenString is invalid, expired, or does
+	// not authorize username.
+	Verify(tokenString, username string) error
+}
+
+// checkSubject confirms a parsed JWT's "sub" claim matches username.
+func checkSubject(token *jwt.Token, username string) error {
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return fmt.Errorf("unexpected claims type")
+	}
+	sub, _ := claims["sub"].(string)
+	if sub != username {
+		return fmt.Errorf("token subject %q does not match requested username %q", sub, username)
+	}
+	return nil
+}
+
+// HMACVerifier verifies shared-secret HMAC-signed JWTs.
+type HMACVerifier struct {
+	secret []byte
+}
+
+// newHMACVerifier builds a TokenVerifier using secret as the HMAC key.
+func newHMACVerifier(secret string) *HMACVerifier {
+	return &HMACVerifier{secret: []byte(secret)}
+}
+
+func (v *HMACVerifier) Verify(tokenString, username string) error {
+	token, err := jwt.Parse(tokenString, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", t.Method)
+		}
+		return v.secret, nil
+	})
+	if err != nil {
+		return fmt.Errorf("invalid token: %w", err)
+	}
+	if !token.Valid {
+		return fmt.Errorf("invalid token")
+	}
+	return checkSubject(token, username)
+}
+
+// JWTVerifier verifies RSA- or ECDSA-signed JWTs against a set of
+// configured public keys.
+type JWTVerifier struct {
+	keys []interface{}
+}
+
+// newJWTVerifier loads PEM-encoded RSA or ECDSA public keys from paths.
+func newJWTVerifier(paths []string) (*JWTVerifier, error) {
+	keys := make([]interface{}, 0, len(paths))
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading public key %s: %w", path, err)
+		}
+
+		var key interface{}
+		if rsaKey, rsaErr := jwt.ParseRSAPublicKeyFromPEM(data); rsaErr == nil {
+			key = rsaKey
+		} else if ecKey, ecErr := jwt.ParseECPublicKeyFromPEM(data); ecErr == nil {
+			key = ecKey
+		} else {
+			return nil, fmt.Errorf("parsing public key %s: not a valid RSA or ECDSA key (%v, %v)", path, rsaErr, ecErr)
+		}
+		keys = append(keys, key)
+	}
+	return &JWTVerifier{keys: keys}, nil
+}
+
+func (v *JWTVerifier) Verify(tokenString, username string) error {
+	var lastErr error
+	for _, key := range v.keys {
+		token, err := jwt.Parse(tokenString, func(t *jwt.Token) (interface{}, error) {
+			switch t.Method.(type) {
+			case *jwt.SigningMethodRSA, *jwt.SigningMethodECDSA:
+				return key, nil
+			default:
+				return nil, fmt.Errorf("unexpected signing method: %v", t.Method)
+			}
+		})
+		if err != nil || !token.Valid {
+			lastErr = err
+			continue
+		}
+		return checkSubject(token, username)
+	}
+	if lastErr == nil {
+		lastErr = fmt.Errorf("no public keys configured")
+	}
+	return fmt.Errorf("token rejected by all configured keys: %w", lastErr)
+}
+
+var (
+	_ TokenVerifier = (*HMACVerifier)(nil)
+	_ TokenVerifier = (*JWTVerifier)(nil)
+)
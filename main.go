@@ -2,14 +2,58 @@ package main
 
 import (
 	"encoding/json"
+	"flag"
+	"fmt"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 )
 
+var (
+	pongWait       = flag.Duration("pong-wait", 60*time.Second, "how long to wait for a pong before closing the connection")
+	pingPeriod     = flag.Duration("ping-period", 0, "how often to ping clients (defaults to 90% of pong-wait)")
+	writeWait      = flag.Duration("write-wait", 10*time.Second, "time allowed to write a message to a client")
+	maxMessageSize = flag.Int64("max-message-size", 4096, "maximum size in bytes of a message read from a client")
+
+	historyStore = flag.String("history-store", "memory", "location history backend: memory, file, or redis")
+	historySize  = flag.Int("history-size", 100, "number of recent points to retain per user")
+	historyPath  = flag.String("history-path", "./history", "directory used by the file history store")
+	redisAddr    = flag.String("redis-addr", "localhost:6379", "address of the Redis server used by the redis history store")
+
+	graphPath = flag.String("graph", "", "path to a CSV file of nodes/edges to enable route_request routing (disabled if empty)")
+
+	proxyConfigPath = flag.String("proxy-config", "", "path to a JSON file of proxy Mappings to enable /proxy/{name} tunneling (disabled if empty)")
+	proxyAuthKeys   = flag.String("proxy-auth-keys", "", "comma-separated paths to PEM RSA public keys required to authorize /proxy/{name} upgrades; leaving this empty allows ANYONE to open a tunnel")
+
+	allowedOrigins = flag.String("allowed-origins", "", "comma-separated allow-list of WebSocket origins (exact match, or *.example.com wildcard suffix)")
+	authHMACSecret = flag.String("auth-hmac-secret", "", "shared secret used to verify HMAC-signed client tokens")
+	authJWTKeys    = flag.String("auth-jwt-keys", "", "comma-separated paths to PEM RSA/ECDSA public keys used to verify client tokens")
+)
+
+// newStore constructs the configured Store backend.
+func newStore() (Store, error) {
+	if *historySize <= 0 {
+		return nil, fmt.Errorf("--history-size must be positive, got %d", *historySize)
+	}
+
+	switch *historyStore {
+	case "memory":
+		return NewMemoryStore(*historySize), nil
+	case "file":
+		return NewFileStore(*historyPath, *historySize)
+	case "redis":
+		return NewRedisStore(*redisAddr, *historySize), nil
+	default:
+		return nil, fmt.Errorf("unknown history store %q", *historyStore)
+	}
+}
+
 // LocationData represents the location data sent by clients
 type LocationData struct {
 	Username  string  `json:"username"`
@@ -30,183 +74,125 @@ type Client struct {
 	Username string
 	Conn     *websocket.Conn
 	Send     chan []byte
+	Room     *Room
 }
 
-// Hub maintains the set of active clients and broadcasts messages to the clients
+// Hub owns the set of active rooms, creating them on demand so each
+// independent group of clients gets its own client set and broadcast channel.
 type Hub struct {
-	// Registered clients
-	clients map[*Client]bool
-
-	// Inbound messages from the clients
-	broadcast chan []byte
-
-	// Register requests from the clients
-	register chan *Client
-
-	// Unregister requests from clients
-	unregister chan *Client
-
 	// Mutex for thread-safe operations
 	mutex sync.RWMutex
 
-	// Store current locations of all users
-	locations map[string]LocationData
-}
+	// Active rooms keyed by name
+	rooms map[string]*Room
 
-func newHub() *Hub {
-	return &Hub{
-		clients:    make(map[*Client]bool),
-		broadcast:  make(chan []byte),
-		register:   make(chan *Client),
-		unregister: make(chan *Client),
-		locations:  make(map[string]LocationData),
-	}
-}
+	// store persists recent location history, shared across all rooms.
+	store Store
 
-func (h *Hub) run() {
-	for {
-		select {
-		case client := <-h.register:
-			h.mutex.Lock()
-			h.clients[client] = true
-			h.mutex.Unlock()
-			
-			log.Printf("Client %s (%s) connected", client.ID, client.Username)
-			
-			// Send current locations to the new client
-			h.sendCurrentLocations(client)
-			
-			// Notify all clients about the new connection
-			message := Message{
-				Type: "user_connected",
-				Data: map[string]string{
-					"username": client.Username,
-					"message":  client.Username + " が接続しました",
-				},
-			}
-			h.broadcastMessage(message)
-
-		case client := <-h.unregister:
-			h.mutex.Lock()
-			if _, ok := h.clients[client]; ok {
-				delete(h.clients, client)
-				close(client.Send)
-				
-				// Remove user's location data
-				delete(h.locations, client.Username)
-				
-				log.Printf("Client %s (%s) disconnected", client.ID, client.Username)
-				
-				// Notify all clients about the disconnection
-				message := Message{
-					Type: "user_disconnected",
-					Data: map[string]string{
-						"username": client.Username,
-						"message":  client.Username + " が切断しました",
-					},
-				}
-				h.broadcastMessage(message)
-			}
-			h.mutex.Unlock()
-
-		case message := <-h.broadcast:
-			h.mutex.RLock()
-			for client := range h.clients {
-				select {
-				case client.Send <- message:
-				default:
-					close(client.Send)
-					delete(h.clients, client)
-				}
-			}
-			h.mutex.RUnlock()
-		}
-	}
+	// graph is the shared routing graph, shared across all rooms. May be
+	// nil if no --graph was configured.
+	graph *Graph
 }
 
-func (h *Hub) sendCurrentLocations(client *Client) {
-	h.mutex.RLock()
-	defer h.mutex.RUnlock()
-	
-	if len(h.locations) > 0 {
-		message := Message{
-			Type: "current_locations",
-			Data: h.locations,
-		}
-		
-		data, err := json.Marshal(message)
-		if err != nil {
-			log.Printf("Error marshaling current locations: %v", err)
-			return
-		}
-		
-		select {
-		case client.Send <- data:
-		default:
-			close(client.Send)
-		}
+func newHub(store Store, graph *Graph) *Hub {
+	return &Hub{
+		rooms: make(map[string]*Room),
+		store: store,
+		graph: graph,
 	}
 }
 
-func (h *Hub) broadcastMessage(message Message) {
-	data, err := json.Marshal(message)
-	if err != nil {
-		log.Printf("Error marshaling message: %v", err)
-		return
-	}
-	
-	select {
-	case h.broadcast <- data:
-	default:
-		log.Println("Broadcast channel is full")
+// getOrCreateRoom returns the named room, creating and starting it if this
+// is the first client to reference it.
+func (h *Hub) getOrCreateRoom(name string) *Room {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	room, ok := h.rooms[name]
+	if !ok {
+		room = newRoom(name, h.store, h.graph, h)
+		h.rooms[name] = room
+		go room.run()
 	}
+	return room
 }
 
-func (h *Hub) updateLocation(username string, location LocationData) {
+// removeRoom deregisters room from name, but only if it is still the
+// current occupant of that name (a fresh room may already have replaced it).
+// Reports whether the removal happened.
+func (h *Hub) removeRoom(name string, room *Room) bool {
 	h.mutex.Lock()
-	h.locations[username] = location
-	h.mutex.Unlock()
-	
-	// Broadcast the location update to all clients
-	message := Message{
-		Type: "location_update",
-		Data: location,
+	defer h.mutex.Unlock()
+
+	if h.rooms[name] != room {
+		return false
 	}
-	h.broadcastMessage(message)
+	delete(h.rooms, name)
+	return true
 }
 
+// devMode restores the historic permissive behavior (any origin, no auth)
+// for local testing only; production deployments should leave it unset.
+var devMode = flag.Bool("dev", false, "disable origin checking and authentication (local testing only)")
+
+// originAllowList and authVerifier are populated in main() once flags are
+// parsed; authVerifier is nil if no auth backend is configured.
+var originAllowList *OriginAllowList
+var authVerifier TokenVerifier
+
 var upgrader = websocket.Upgrader{
 	CheckOrigin: func(r *http.Request) bool {
-		// Allow connections from any origin (for development)
-		// In production, you should implement proper origin checking
-		return true
+		if *devMode {
+			return true
+		}
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			return false
+		}
+		return originAllowList.Allowed(origin)
 	},
 }
 
 func (c *Client) writePump() {
+	ticker := time.NewTicker(*pingPeriod)
 	defer func() {
+		ticker.Stop()
 		c.Conn.Close()
 	}()
-	
+
 	for {
 		select {
 		case message, ok := <-c.Send:
+			c.Conn.SetWriteDeadline(time.Now().Add(*writeWait))
 			if !ok {
 				c.Conn.WriteMessage(websocket.CloseMessage, []byte{})
 				return
 			}
-			
+
 			c.Conn.WriteMessage(websocket.TextMessage, message)
+
+		case <-ticker.C:
+			c.Conn.SetWriteDeadline(time.Now().Add(*writeWait))
+			if err := c.Conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
 		}
 	}
 }
 
-func (c *Client) readPump(hub *Hub) {
+func (c *Client) readPump() {
 	defer func() {
-		hub.unregister <- c
+		c.Room.unregister <- c
 		c.Conn.Close()
 	}()
-	
+
+	c.Conn.SetReadLimit(*maxMessageSize)
+	c.Conn.SetReadDeadline(time.Now().Add(*pongWait))
+	c.Conn.SetPongHandler(func(string) error {
+		c.Conn.SetReadDeadline(time.Now().Add(*pongWait))
+		return nil
+	})
+
 	for {
 		_, message, err := c.Conn.ReadMessage()
 		if err != nil {
@@ -242,51 +228,188 @@ func (c *Client) readPump(hub *Hub) {
 			log.Printf("Received location update from %s: lat=%f, lon=%f", 
 				c.Username, locationData.Latitude, locationData.Longitude)
 			
-			hub.updateLocation(c.Username, locationData)
+			c.Room.updateLocation(c.Username, locationData)
+
+		case "route_request":
+			c.Room.handleRouteRequest(c, msg.Data)
 		}
 	}
 }
 
 func handleWebSocket(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	// Get username from query parameters before authenticating, since the
+	// token must authorize this specific username.
+	username := r.URL.Query().Get("username")
+	if username == "" {
+		username = "Anonymous"
+	}
+
+	if !*devMode {
+		if authVerifier == nil {
+			http.Error(w, "authentication is not configured on this server", http.StatusForbidden)
+			return
+		}
+		token := r.URL.Query().Get("token")
+		if token == "" {
+			http.Error(w, "missing token", http.StatusUnauthorized)
+			return
+		}
+		if err := authVerifier.Verify(token, username); err != nil {
+			log.Printf("Rejected WebSocket connection for %q: %v", username, err)
+			http.Error(w, "invalid token", http.StatusForbidden)
+			return
+		}
+	}
+
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Printf("WebSocket upgrade error: %v", err)
 		return
 	}
-	
-	// Get username from query parameter
-	username := r.URL.Query().Get("username")
-	if username == "" {
-		username = "Anonymous"
+
+	roomName := r.URL.Query().Get("room")
+	if roomName == "" {
+		roomName = defaultRoom
 	}
-	
+
 	client := &Client{
 		ID:       generateClientID(),
 		Username: username,
 		Conn:     conn,
 		Send:     make(chan []byte, 256),
 	}
-	
-	hub.register <- client
-	
+
+	// A room can shut itself down between getOrCreateRoom and the register
+	// send below once it empties; retry against a fresh room if that race
+	// is lost instead of blocking forever on a dead room's channel.
+	for {
+		room := hub.getOrCreateRoom(roomName)
+		select {
+		case room.register <- client:
+			client.Room = room
+		case <-room.done:
+			continue
+		}
+		break
+	}
+
 	// Start goroutines for reading and writing
 	go client.writePump()
-	go client.readPump(hub)
+	go client.readPump()
 }
 
 func generateClientID() string {
 	return uuid.New().String()
 }
 
+// handleHistory serves GET /history?username=...&room=...&since=... for
+// non-WebSocket clients such as a map replay UI.
+func handleHistory(store Store, w http.ResponseWriter, r *http.Request) {
+	username := r.URL.Query().Get("username")
+	if username == "" {
+		http.Error(w, "username is required", http.StatusBadRequest)
+		return
+	}
+
+	roomName := r.URL.Query().Get("room")
+	if roomName == "" {
+		roomName = defaultRoom
+	}
+
+	var since int64
+	if s := r.URL.Query().Get("since"); s != "" {
+		parsed, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			http.Error(w, "since must be a unix millisecond timestamp", http.StatusBadRequest)
+			return
+		}
+		since = parsed
+	}
+
+	track, err := store.History(roomName, username, since)
+	if err != nil {
+		log.Printf("Error loading history for %s in room %q: %v", username, roomName, err)
+		http.Error(w, "failed to load history", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(track); err != nil {
+		log.Printf("Error encoding history response: %v", err)
+	}
+}
+
 func main() {
-	hub := newHub()
-	go hub.run()
-	
+	flag.Parse()
+	if *pingPeriod == 0 {
+		*pingPeriod = (*pongWait * 9) / 10
+	}
+
+	var err error
+	if *devMode {
+		log.Println("WARNING: running with --dev: origin checking and authentication are disabled")
+	} else {
+		originAllowList = newOriginAllowList(*allowedOrigins)
+
+		switch {
+		case *authHMACSecret != "":
+			authVerifier = newHMACVerifier(*authHMACSecret)
+		case *authJWTKeys != "":
+			authVerifier, err = newJWTVerifier(strings.Split(*authJWTKeys, ","))
+			if err != nil {
+				log.Fatal("Failed to load auth JWT keys:", err)
+			}
+		}
+	}
+
+	store, err := newStore()
+	if err != nil {
+		log.Fatal("Failed to initialize history store:", err)
+	}
+
+	var graph *Graph
+	if *graphPath != "" {
+		graph, err = loadGraph(*graphPath)
+		if err != nil {
+			log.Fatal("Failed to load routing graph:", err)
+		}
+		log.Printf("Loaded routing graph from %s", *graphPath)
+	}
+
+	hub := newHub(store, graph)
+
 	// WebSocket endpoint
 	http.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
 		handleWebSocket(hub, w, r)
 	})
-	
+
+	// Proxy tunneling endpoint
+	if *proxyConfigPath != "" {
+		mappings, err := loadMappings(*proxyConfigPath)
+		if err != nil {
+			log.Fatal("Failed to load proxy config:", err)
+		}
+
+		var verifier *PublicKeyVerifier
+		if *proxyAuthKeys != "" {
+			verifier, err = newPublicKeyVerifier(strings.Split(*proxyAuthKeys, ","))
+			if err != nil {
+				log.Fatal("Failed to load proxy auth keys:", err)
+			}
+		} else {
+			log.Println("WARNING: --proxy-config is set without --proxy-auth-keys: /proxy/{name} tunnels are reachable with no authentication")
+		}
+
+		proxyServer := newProxyServer(mappings, verifier)
+		http.HandleFunc("/proxy/", proxyServer.handleProxy)
+		log.Printf("Loaded %d proxy mapping(s) from %s", len(mappings), *proxyConfigPath)
+	}
+
+	// Location history endpoint
+	http.HandleFunc("/history", func(w http.ResponseWriter, r *http.Request) {
+		handleHistory(store, w, r)
+	})
+
 	// Health check endpoint
 	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
@@ -310,7 +433,7 @@ func main() {
 	
 	port := ":8080"
 	log.Printf("WebSocket server starting on port %s", port)
-	log.Printf("WebSocket endpoint: ws://localhost%s/ws?username=<your_username>", port)
+	log.Printf("WebSocket endpoint: ws://localhost%s/ws?username=<your_username>&room=<room_name>", port)
 	
 	if err := http.ListenAndServe(port, nil); err != nil {
 		log.Fatal("Server failed to start:", err)
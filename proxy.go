@@ -0,0 +1,252 @@
+package main
+
+import (
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/websocket"
+)
+
+// Mapping describes one named TCP/UDP backend a /proxy/{name} tunnel may dial.
+type Mapping struct {
+	Name         string        `json:"name"`
+	Network      string        `json:"network"` // "tcp" or "udp"; defaults to "tcp"
+	Backend      string        `json:"backend"` // host:port
+	DialTimeout  time.Duration `json:"dial_timeout"`
+	WriteTimeout time.Duration `json:"write_timeout"`
+}
+
+// loadMappings reads a JSON array of Mapping from path, keyed by name.
+func loadMappings(path string) (map[string]Mapping, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading proxy config: %w", err)
+	}
+
+	var list []Mapping
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("parsing proxy config: %w", err)
+	}
+
+	mappings := make(map[string]Mapping, len(list))
+	for _, m := range list {
+		if m.Network == "" {
+			m.Network = "tcp"
+		}
+		if m.DialTimeout == 0 {
+			m.DialTimeout = 5 * time.Second
+		}
+		if m.WriteTimeout == 0 {
+			m.WriteTimeout = 30 * time.Second
+		}
+		mappings[m.Name] = m
+	}
+	return mappings, nil
+}
+
+// PublicKeyVerifier validates a JWT Authorization header against a set of
+// configured RSA public keys before a /proxy/{name} upgrade is allowed.
+type PublicKeyVerifier struct {
+	keys []*rsa.PublicKey
+}
+
+// newPublicKeyVerifier loads PEM-encoded RSA public keys from the given files.
+func newPublicKeyVerifier(paths []string) (*PublicKeyVerifier, error) {
+	keys := make([]*rsa.PublicKey, 0, len(paths))
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading public key %s: %w", path, err)
+		}
+		key, err := jwt.ParseRSAPublicKeyFromPEM(data)
+		if err != nil {
+			return nil, fmt.Errorf("parsing public key %s: %w", path, err)
+		}
+		keys = append(keys, key)
+	}
+	return &PublicKeyVerifier{keys: keys}, nil
+}
+
+// Verify checks tokenString's signature against each configured public key
+// and returns the token's "sub" claim on the first successful match.
+func (v *PublicKeyVerifier) Verify(tokenString string) (string, error) {
+	var lastErr error
+	for _, key := range v.keys {
+		token, err := jwt.Parse(tokenString, func(t *jwt.Token) (interface{}, error) {
+			if _, ok := t.Method.(*jwt.SigningMethodRSA); !ok {
+				return nil, fmt.Errorf("unexpected signing method: %v", t.Method)
+			}
+			return key, nil
+		})
+		if err != nil || !token.Valid {
+			lastErr = err
+			continue
+		}
+		claims, ok := token.Claims.(jwt.MapClaims)
+		if !ok {
+			lastErr = fmt.Errorf("unexpected claims type")
+			continue
+		}
+		sub, _ := claims["sub"].(string)
+		return sub, nil
+	}
+	if lastErr == nil {
+		lastErr = fmt.Errorf("no public keys configured")
+	}
+	return "", fmt.Errorf("token rejected by all configured keys: %w", lastErr)
+}
+
+// ProxyServer upgrades /proxy/{name} requests to WebSocket and pipes binary
+// frames bidirectionally to the mapping's configured backend, turning the
+// server into a general-purpose WebSocket gateway.
+type ProxyServer struct {
+	mappings map[string]Mapping
+	verifier *PublicKeyVerifier
+}
+
+func newProxyServer(mappings map[string]Mapping, verifier *PublicKeyVerifier) *ProxyServer {
+	return &ProxyServer{mappings: mappings, verifier: verifier}
+}
+
+// handleProxy is the http.HandlerFunc for /proxy/{name}.
+func (p *ProxyServer) handleProxy(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/proxy/")
+	mapping, ok := p.mappings[name]
+	if !ok {
+		http.Error(w, "unknown proxy mapping", http.StatusNotFound)
+		return
+	}
+
+	if p.verifier != nil {
+		tokenString := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if tokenString == "" {
+			http.Error(w, "missing bearer token", http.StatusUnauthorized)
+			return
+		}
+		if _, err := p.verifier.Verify(tokenString); err != nil {
+			log.Printf("Proxy %q rejected token: %v", name, err)
+			http.Error(w, "invalid token", http.StatusForbidden)
+			return
+		}
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("Proxy upgrade error for %q: %v", name, err)
+		return
+	}
+	defer conn.Close()
+
+	backend, err := net.DialTimeout(mapping.Network, mapping.Backend, mapping.DialTimeout)
+	if err != nil {
+		log.Printf("Proxy dial error for %q: %v", name, err)
+		conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseInternalServerErr, "backend unreachable"))
+		return
+	}
+	defer backend.Close()
+
+	log.Printf("Proxy %q tunneling to %s", name, mapping.Backend)
+
+	// gorilla/websocket frames aren't an io.Reader/Writer pair, so each
+	// direction gets its own read-loop-then-forward copy instead of a
+	// single io.Copy.
+	done := make(chan struct{}, 2)
+	go func() {
+		copyBackendToWebSocket(conn, backend, mapping.WriteTimeout)
+		done <- struct{}{}
+	}()
+	go func() {
+		copyWebSocketToBackend(conn, backend)
+		done <- struct{}{}
+	}()
+	<-done
+}
+
+// copyBackendToWebSocket reads raw bytes from backend and forwards each read
+// as a binary WebSocket frame, pinging on the same cadence as the /ws pumps
+// so a dropped client is detected instead of leaking the backend socket.
+func copyBackendToWebSocket(conn *websocket.Conn, backend net.Conn, writeTimeout time.Duration) {
+	ticker := time.NewTicker(*pingPeriod)
+	defer ticker.Stop()
+
+	// stop tells the inner reader goroutine to give up once this function
+	// returns, so it never blocks forever trying to send on reads with
+	// nobody left to receive it.
+	stop := make(chan struct{})
+	defer close(stop)
+
+	type backendRead struct {
+		data []byte
+		err  error
+	}
+	reads := make(chan backendRead)
+	go func() {
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := backend.Read(buf)
+			chunk := append([]byte(nil), buf[:n]...)
+			select {
+			case reads <- backendRead{data: chunk, err: err}:
+			case <-stop:
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case read := <-reads:
+			if len(read.data) > 0 {
+				conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+				if err := conn.WriteMessage(websocket.BinaryMessage, read.data); err != nil {
+					return
+				}
+			}
+			if read.err != nil {
+				return
+			}
+
+		case <-ticker.C:
+			conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// copyWebSocketToBackend reads binary frames from conn and writes their
+// payload to backend, applying the same size limit and read deadline as the
+// /ws pumps so a half-open tunnel doesn't run forever.
+func copyWebSocketToBackend(conn *websocket.Conn, backend net.Conn) {
+	conn.SetReadLimit(*maxMessageSize)
+	conn.SetReadDeadline(time.Now().Add(*pongWait))
+	conn.SetPongHandler(func(string) error {
+		conn.SetReadDeadline(time.Now().Add(*pongWait))
+		return nil
+	})
+
+	for {
+		messageType, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		if messageType != websocket.BinaryMessage {
+			continue
+		}
+		if _, err := backend.Write(data); err != nil {
+			return
+		}
+	}
+}
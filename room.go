@@ -0,0 +1,275 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+)
+
+// defaultRoom is used when a client connects without specifying a room.
+const defaultRoom = "default"
+
+// Room scopes a set of clients, their locations, and their broadcasts to a
+// single topic so unrelated groups of clients never see each other's data.
+type Room struct {
+	// Name identifies the room, e.g. the "room" query parameter.
+	Name string
+
+	// Registered clients
+	clients map[*Client]bool
+
+	// Inbound messages from the clients
+	broadcast chan []byte
+
+	// Register requests from the clients
+	register chan *Client
+
+	// Unregister requests from clients
+	unregister chan *Client
+
+	// Mutex for thread-safe operations
+	mutex sync.RWMutex
+
+	// Store current locations of all users in the room
+	locations map[string]LocationData
+
+	// store persists recent location history for replay to late joiners.
+	// May be nil if history replay is disabled.
+	store Store
+
+	// graph is the shared routing graph used to answer route_request
+	// messages. May be nil if no --graph was configured.
+	graph *Graph
+
+	// hub is the owning Hub, used to deregister this room once it empties.
+	hub *Hub
+
+	// done is closed once run() returns, so a registration that raced with
+	// shutdown can detect it and retry against a fresh room instead of
+	// blocking forever on a register channel nobody is reading anymore.
+	done chan struct{}
+
+	// outbox carries unicast deliveries (e.g. route responses) from other
+	// goroutines into run(), which is the only goroutine allowed to write
+	// to or close a Client's Send channel.
+	outbox chan clientMessage
+}
+
+// clientMessage is a single unicast delivery queued on a Room's outbox.
+type clientMessage struct {
+	client *Client
+	data   []byte
+}
+
+func newRoom(name string, store Store, graph *Graph, hub *Hub) *Room {
+	return &Room{
+		Name:       name,
+		clients:    make(map[*Client]bool),
+		broadcast:  make(chan []byte, 256),
+		register:   make(chan *Client),
+		unregister: make(chan *Client),
+		locations:  make(map[string]LocationData),
+		store:      store,
+		graph:      graph,
+		hub:        hub,
+		done:       make(chan struct{}),
+		outbox:     make(chan clientMessage),
+	}
+}
+
+func (r *Room) run() {
+	defer close(r.done)
+
+	for {
+		select {
+		case client := <-r.register:
+			r.mutex.Lock()
+			r.clients[client] = true
+			r.mutex.Unlock()
+
+			log.Printf("Client %s (%s) connected to room %q", client.ID, client.Username, r.Name)
+
+			// Send current locations and recent history to the new client
+			r.sendCurrentLocations(client)
+			r.sendHistory(client)
+
+			// Notify all clients in the room about the new connection
+			message := Message{
+				Type: "user_connected",
+				Data: map[string]string{
+					"username": client.Username,
+					"message":  client.Username + " が接続しました",
+				},
+			}
+			r.broadcastMessage(message)
+
+		case client := <-r.unregister:
+			r.mutex.Lock()
+			if _, ok := r.clients[client]; ok {
+				delete(r.clients, client)
+				close(client.Send)
+
+				// Remove user's location data
+				delete(r.locations, client.Username)
+
+				log.Printf("Client %s (%s) disconnected from room %q", client.ID, client.Username, r.Name)
+
+				// Notify all clients in the room about the disconnection
+				message := Message{
+					Type: "user_disconnected",
+					Data: map[string]string{
+						"username": client.Username,
+						"message":  client.Username + " が切断しました",
+					},
+				}
+				r.broadcastMessage(message)
+			}
+			empty := len(r.clients) == 0
+			r.mutex.Unlock()
+
+			// A client-controlled room name must not leak a goroutine and a
+			// map entry forever; tear down once the last client leaves.
+			if empty && r.hub.removeRoom(r.Name, r) {
+				log.Printf("Room %q is empty, shutting down", r.Name)
+				return
+			}
+
+		case message := <-r.broadcast:
+			r.mutex.RLock()
+			for client := range r.clients {
+				select {
+				case client.Send <- message:
+				default:
+					close(client.Send)
+					delete(r.clients, client)
+				}
+			}
+			r.mutex.RUnlock()
+
+		case out := <-r.outbox:
+			r.mutex.Lock()
+			if _, ok := r.clients[out.client]; ok {
+				select {
+				case out.client.Send <- out.data:
+				default:
+					close(out.client.Send)
+					delete(r.clients, out.client)
+				}
+			}
+			r.mutex.Unlock()
+		}
+	}
+}
+
+// deliver queues data for unicast delivery to client, handled by run() so
+// that goroutine remains the only writer/closer of client.Send. It is safe
+// to call from any goroutine, including a client's own readPump.
+func (r *Room) deliver(client *Client, data []byte) {
+	select {
+	case r.outbox <- clientMessage{client: client, data: data}:
+	case <-r.done:
+	}
+}
+
+func (r *Room) sendCurrentLocations(client *Client) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	if len(r.locations) > 0 {
+		message := Message{
+			Type: "current_locations",
+			Data: r.locations,
+		}
+
+		data, err := json.Marshal(message)
+		if err != nil {
+			log.Printf("Error marshaling current locations: %v", err)
+			return
+		}
+
+		select {
+		case client.Send <- data:
+		default:
+			close(client.Send)
+		}
+	}
+}
+
+// sendHistory replays each currently known user's recent track to client as
+// a location_history message, so late joiners see where users have been.
+func (r *Room) sendHistory(client *Client) {
+	if r.store == nil {
+		return
+	}
+
+	r.mutex.RLock()
+	usernames := make([]string, 0, len(r.locations))
+	for username := range r.locations {
+		usernames = append(usernames, username)
+	}
+	r.mutex.RUnlock()
+
+	tracks := make(map[string][]LocationData, len(usernames))
+	for _, username := range usernames {
+		track, err := r.store.History(r.Name, username, 0)
+		if err != nil {
+			log.Printf("Error loading history for %s in room %q: %v", username, r.Name, err)
+			continue
+		}
+		if len(track) > 0 {
+			tracks[username] = track
+		}
+	}
+	if len(tracks) == 0 {
+		return
+	}
+
+	message := Message{
+		Type: "location_history",
+		Data: tracks,
+	}
+	data, err := json.Marshal(message)
+	if err != nil {
+		log.Printf("Error marshaling location history: %v", err)
+		return
+	}
+
+	select {
+	case client.Send <- data:
+	default:
+		close(client.Send)
+	}
+}
+
+func (r *Room) broadcastMessage(message Message) {
+	data, err := json.Marshal(message)
+	if err != nil {
+		log.Printf("Error marshaling message: %v", err)
+		return
+	}
+
+	select {
+	case r.broadcast <- data:
+	default:
+		log.Println("Broadcast channel is full")
+	}
+}
+
+func (r *Room) updateLocation(username string, location LocationData) {
+	r.mutex.Lock()
+	r.locations[username] = location
+	r.mutex.Unlock()
+
+	if r.store != nil {
+		if err := r.store.Append(r.Name, username, location); err != nil {
+			log.Printf("Error persisting location history for %s in room %q: %v", username, r.Name, err)
+		}
+	}
+
+	// Broadcast the location update to clients in the room
+	message := Message{
+		Type: "location_update",
+		Data: location,
+	}
+	r.broadcastMessage(message)
+}
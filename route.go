@@ -0,0 +1,341 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"strconv"
+)
+
+// GraphNode is a single routable point loaded from the --graph file.
+type GraphNode struct {
+	ID  string
+	Lat float64
+	Lon float64
+}
+
+type graphEdge struct {
+	from, to string
+	weight   float64
+}
+
+// Graph is a road/waypoint graph with all-pairs shortest paths precomputed
+// via Floyd-Warshall, so route queries against a fixed map are O(path
+// length) instead of paying per-query Dijkstra cost.
+type Graph struct {
+	nodes []GraphNode
+	index map[string]int
+
+	// dist[i][j] is the shortest known distance from node i to node j.
+	dist [][]float64
+
+	// via[i][j] is the pivot node used to split the i->j path during
+	// reconstruction; via[i][j] == i marks a direct edge (or no path).
+	via [][]int
+}
+
+// loadGraph reads a CSV file describing nodes and edges and returns a Graph
+// with all-pairs shortest paths precomputed. Each line is one of:
+//
+//	node,<id>,<lat>,<lon>
+//	edge,<from_id>,<to_id>,<weight>
+func loadGraph(path string) (*Graph, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening graph file: %w", err)
+	}
+	defer f.Close()
+
+	reader := csv.NewReader(bufio.NewReader(f))
+	reader.FieldsPerRecord = -1
+
+	var nodes []GraphNode
+	var edges []graphEdge
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading graph file: %w", err)
+		}
+		if len(record) == 0 {
+			continue
+		}
+
+		switch record[0] {
+		case "node":
+			if len(record) != 4 {
+				return nil, fmt.Errorf("malformed node record: %v", record)
+			}
+			lat, err := strconv.ParseFloat(record[2], 64)
+			if err != nil {
+				return nil, fmt.Errorf("parsing node latitude: %w", err)
+			}
+			lon, err := strconv.ParseFloat(record[3], 64)
+			if err != nil {
+				return nil, fmt.Errorf("parsing node longitude: %w", err)
+			}
+			nodes = append(nodes, GraphNode{ID: record[1], Lat: lat, Lon: lon})
+
+		case "edge":
+			if len(record) != 4 {
+				return nil, fmt.Errorf("malformed edge record: %v", record)
+			}
+			weight, err := strconv.ParseFloat(record[3], 64)
+			if err != nil {
+				return nil, fmt.Errorf("parsing edge weight: %w", err)
+			}
+			edges = append(edges, graphEdge{from: record[1], to: record[2], weight: weight})
+		}
+	}
+
+	return buildGraph(nodes, edges), nil
+}
+
+func buildGraph(nodes []GraphNode, edges []graphEdge) *Graph {
+	n := len(nodes)
+	index := make(map[string]int, n)
+	for i, node := range nodes {
+		index[node.ID] = i
+	}
+
+	dist := make([][]float64, n)
+	via := make([][]int, n)
+	for i := range dist {
+		dist[i] = make([]float64, n)
+		via[i] = make([]int, n)
+		for j := range dist[i] {
+			if i == j {
+				dist[i][j] = 0
+			} else {
+				dist[i][j] = math.Inf(1)
+			}
+			via[i][j] = i
+		}
+	}
+
+	for _, e := range edges {
+		i, ok := index[e.from]
+		if !ok {
+			continue
+		}
+		j, ok := index[e.to]
+		if !ok {
+			continue
+		}
+		if e.weight < dist[i][j] {
+			dist[i][j] = e.weight
+			dist[j][i] = e.weight
+		}
+	}
+
+	g := &Graph{nodes: nodes, index: index, dist: dist, via: via}
+	g.floydWarshall()
+	return g
+}
+
+// floydWarshall computes all-pairs shortest paths in O(N^3), run once at
+// startup so subsequent route queries are O(path length).
+func (g *Graph) floydWarshall() {
+	n := len(g.nodes)
+	for k := 0; k < n; k++ {
+		for i := 0; i < n; i++ {
+			for j := 0; j < n; j++ {
+				if g.dist[i][k]+g.dist[k][j] < g.dist[i][j] {
+					g.dist[i][j] = g.dist[i][k] + g.dist[k][j]
+					g.via[i][j] = k
+				}
+			}
+		}
+	}
+}
+
+// reconstruct appends the node IDs strictly between i and j (excluding both
+// endpoints) to path, recursively splitting the path at its pivot. When
+// via[i][j] == i there is a direct edge from i to j, so no interior node is
+// emitted for that sub-range.
+func (g *Graph) reconstruct(i, j int, path *[]string) {
+	if i == j {
+		return
+	}
+	k := g.via[i][j]
+	if k == i {
+		return
+	}
+	g.reconstruct(i, k, path)
+	*path = append(*path, g.nodes[k].ID)
+	g.reconstruct(k, j, path)
+}
+
+// ShortestPath returns the ordered node IDs from fromID to toID (inclusive
+// of both endpoints) and the total distance.
+func (g *Graph) ShortestPath(fromID, toID string) ([]string, float64, error) {
+	i, ok := g.index[fromID]
+	if !ok {
+		return nil, 0, fmt.Errorf("unknown graph node %q", fromID)
+	}
+	j, ok := g.index[toID]
+	if !ok {
+		return nil, 0, fmt.Errorf("unknown graph node %q", toID)
+	}
+	if math.IsInf(g.dist[i][j], 1) {
+		return nil, 0, fmt.Errorf("no path between %q and %q", fromID, toID)
+	}
+
+	path := []string{fromID}
+	g.reconstruct(i, j, &path)
+	path = append(path, toID)
+
+	return path, g.dist[i][j], nil
+}
+
+// nearestNode returns the ID of the graph node closest to (lat, lon).
+func (g *Graph) nearestNode(lat, lon float64) (string, error) {
+	if len(g.nodes) == 0 {
+		return "", fmt.Errorf("graph has no nodes")
+	}
+
+	best := g.nodes[0]
+	bestDist := haversine(lat, lon, best.Lat, best.Lon)
+	for _, node := range g.nodes[1:] {
+		d := haversine(lat, lon, node.Lat, node.Lon)
+		if d < bestDist {
+			best, bestDist = node, d
+		}
+	}
+	return best.ID, nil
+}
+
+// haversine returns the great-circle distance in meters between two
+// lat/lon points.
+func haversine(lat1, lon1, lat2, lon2 float64) float64 {
+	const earthRadiusMeters = 6371000
+	phi1 := lat1 * math.Pi / 180
+	phi2 := lat2 * math.Pi / 180
+	dPhi := (lat2 - lat1) * math.Pi / 180
+	dLambda := (lon2 - lon1) * math.Pi / 180
+
+	a := math.Sin(dPhi/2)*math.Sin(dPhi/2) +
+		math.Cos(phi1)*math.Cos(phi2)*math.Sin(dLambda/2)*math.Sin(dLambda/2)
+	return earthRadiusMeters * 2 * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+}
+
+// RouteRequest is the payload of a "route_request" message. Either the
+// username pair or the explicit lat/lon pairs may be used to name the
+// endpoints.
+type RouteRequest struct {
+	FromUsername string  `json:"from_username,omitempty"`
+	ToUsername   string  `json:"to_username,omitempty"`
+	FromLat      float64 `json:"from_lat,omitempty"`
+	FromLon      float64 `json:"from_lon,omitempty"`
+	ToLat        float64 `json:"to_lat,omitempty"`
+	ToLon        float64 `json:"to_lon,omitempty"`
+}
+
+// RouteResponse is the payload of a "route_response" message.
+type RouteResponse struct {
+	Nodes    []string `json:"nodes"`
+	Distance float64  `json:"distance"`
+}
+
+// handleRouteRequest resolves a route_request from client and sends a
+// route_response (or an error message) back to that client only.
+func (r *Room) handleRouteRequest(client *Client, data interface{}) {
+	if r.graph == nil {
+		r.sendError(client, "routing is not configured on this server")
+		return
+	}
+
+	reqBytes, err := json.Marshal(data)
+	if err != nil {
+		log.Printf("Error marshaling route request: %v", err)
+		return
+	}
+	var req RouteRequest
+	if err := json.Unmarshal(reqBytes, &req); err != nil {
+		log.Printf("Error unmarshaling route request: %v", err)
+		return
+	}
+
+	fromLat, fromLon, err := r.resolveEndpoint(req.FromUsername, req.FromLat, req.FromLon)
+	if err != nil {
+		r.sendError(client, err.Error())
+		return
+	}
+	toLat, toLon, err := r.resolveEndpoint(req.ToUsername, req.ToLat, req.ToLon)
+	if err != nil {
+		r.sendError(client, err.Error())
+		return
+	}
+
+	fromNode, err := r.graph.nearestNode(fromLat, fromLon)
+	if err != nil {
+		r.sendError(client, err.Error())
+		return
+	}
+	toNode, err := r.graph.nearestNode(toLat, toLon)
+	if err != nil {
+		r.sendError(client, err.Error())
+		return
+	}
+
+	nodes, distance, err := r.graph.ShortestPath(fromNode, toNode)
+	if err != nil {
+		r.sendError(client, err.Error())
+		return
+	}
+
+	r.sendMessage(client, Message{
+		Type: "route_response",
+		Data: RouteResponse{Nodes: nodes, Distance: distance},
+	})
+}
+
+// resolveEndpoint returns a lat/lon pair for a route endpoint, preferring
+// the named user's last known location and falling back to explicit
+// coordinates when no username is given.
+func (r *Room) resolveEndpoint(username string, lat, lon float64) (float64, float64, error) {
+	if username == "" {
+		return lat, lon, nil
+	}
+	loc, ok := r.locationOf(username)
+	if !ok {
+		return 0, 0, fmt.Errorf("no known location for user %q", username)
+	}
+	return loc.Latitude, loc.Longitude, nil
+}
+
+func (r *Room) locationOf(username string) (LocationData, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	loc, ok := r.locations[username]
+	return loc, ok
+}
+
+// sendMessage marshals and delivers message to a single client. Delivery is
+// queued on the room's outbox rather than writing client.Send directly,
+// since this is called from the client's own readPump goroutine and only
+// run() may write to or close that channel.
+func (r *Room) sendMessage(client *Client, message Message) {
+	data, err := json.Marshal(message)
+	if err != nil {
+		log.Printf("Error marshaling message: %v", err)
+		return
+	}
+	r.deliver(client, data)
+}
+
+// sendError delivers an "error" message to a single client.
+func (r *Room) sendError(client *Client, reason string) {
+	r.sendMessage(client, Message{
+		Type: "error",
+		Data: map[string]string{"message": reason},
+	})
+}
@@ -0,0 +1,54 @@
+package main
+
+import "testing"
+
+func TestShortestPathDirectEdge(t *testing.T) {
+	g := buildGraph(
+		[]GraphNode{{ID: "A"}, {ID: "B"}},
+		[]graphEdge{{from: "A", to: "B", weight: 5}},
+	)
+
+	nodes, distance, err := g.ShortestPath("A", "B")
+	if err != nil {
+		t.Fatalf("ShortestPath returned error: %v", err)
+	}
+	if want := []string{"A", "B"}; !equalNodeSequence(nodes, want) {
+		t.Errorf("nodes = %v, want %v", nodes, want)
+	}
+	if distance != 5 {
+		t.Errorf("distance = %v, want 5", distance)
+	}
+}
+
+func TestShortestPathMultiHop(t *testing.T) {
+	g := buildGraph(
+		[]GraphNode{{ID: "A"}, {ID: "B"}, {ID: "C"}},
+		[]graphEdge{
+			{from: "A", to: "B", weight: 1},
+			{from: "B", to: "C", weight: 1},
+		},
+	)
+
+	nodes, distance, err := g.ShortestPath("A", "C")
+	if err != nil {
+		t.Fatalf("ShortestPath returned error: %v", err)
+	}
+	if want := []string{"A", "B", "C"}; !equalNodeSequence(nodes, want) {
+		t.Errorf("nodes = %v, want %v", nodes, want)
+	}
+	if distance != 2 {
+		t.Errorf("distance = %v, want 2", distance)
+	}
+}
+
+func equalNodeSequence(got, want []string) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
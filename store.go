@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Store persists a bounded history of recent locations per room/user so late
+// joiners can replay where a user has been, not just where they are now.
+type Store interface {
+	// Append records a new location point for username in room, trimming
+	// the retained history to the configured size.
+	Append(room, username string, loc LocationData) error
+
+	// History returns the retained points for username in room at or after
+	// the since timestamp (unix milliseconds), oldest first. since == 0
+	// returns the full retained history.
+	History(room, username string, since int64) ([]LocationData, error)
+}
+
+func trackKey(room, username string) string {
+	return room + "/" + username
+}
+
+// trackFileName and keyFromFileName are inverses used by FileStore to persist
+// a track's key (room/username) as a filesystem-safe name.
+func trackFileName(key string) string {
+	return url.PathEscape(key) + ".json"
+}
+
+func keyFromFileName(name string) string {
+	base := strings.TrimSuffix(name, ".json")
+	key, err := url.PathUnescape(base)
+	if err != nil {
+		return base
+	}
+	return key
+}
+
+func filterSince(track []LocationData, since int64) []LocationData {
+	if since <= 0 {
+		return track
+	}
+	filtered := make([]LocationData, 0, len(track))
+	for _, loc := range track {
+		if loc.Timestamp >= since {
+			filtered = append(filtered, loc)
+		}
+	}
+	return filtered
+}
+
+// MemoryStore keeps the last size points per room/user in memory. It is the
+// default Store and is also used by FileStore as its in-memory cache.
+type MemoryStore struct {
+	mutex  sync.RWMutex
+	size   int
+	tracks map[string][]LocationData
+}
+
+// NewMemoryStore creates an in-memory Store retaining up to size points per user.
+func NewMemoryStore(size int) *MemoryStore {
+	return &MemoryStore{
+		size:   size,
+		tracks: make(map[string][]LocationData),
+	}
+}
+
+func (s *MemoryStore) Append(room, username string, loc LocationData) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	key := trackKey(room, username)
+	track := append(s.tracks[key], loc)
+	if s.size > 0 && len(track) > s.size {
+		track = track[len(track)-s.size:]
+	}
+	s.tracks[key] = track
+	return nil
+}
+
+func (s *MemoryStore) History(room, username string, since int64) ([]LocationData, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return filterSince(s.tracks[trackKey(room, username)], since), nil
+}
+
+// FileStore wraps a MemoryStore for reads and persists each user's track to
+// a JSON file on disk so history survives a server restart.
+type FileStore struct {
+	mem *MemoryStore
+	dir string
+}
+
+// NewFileStore creates a FileStore rooted at dir, loading any tracks already
+// persisted there, and retaining up to size points per user.
+func NewFileStore(dir string, size int) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("creating history directory: %w", err)
+	}
+
+	fs := &FileStore{mem: NewMemoryStore(size), dir: dir}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("reading history directory: %w", err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			continue
+		}
+		var track []LocationData
+		if err := json.Unmarshal(data, &track); err != nil {
+			continue
+		}
+		fs.mem.tracks[keyFromFileName(entry.Name())] = track
+	}
+	return fs, nil
+}
+
+func (fs *FileStore) Append(room, username string, loc LocationData) error {
+	if err := fs.mem.Append(room, username, loc); err != nil {
+		return err
+	}
+
+	track, err := fs.mem.History(room, username, 0)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(track)
+	if err != nil {
+		return fmt.Errorf("marshaling track: %w", err)
+	}
+
+	path := filepath.Join(fs.dir, trackFileName(trackKey(room, username)))
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("writing track file: %w", err)
+	}
+	return nil
+}
+
+func (fs *FileStore) History(room, username string, since int64) ([]LocationData, error) {
+	return fs.mem.History(room, username, since)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisStore persists tracks in a Redis list per room/user so history
+// survives restarts and can be shared across multiple server instances.
+type RedisStore struct {
+	client *redis.Client
+	size   int64
+}
+
+// NewRedisStore creates a Store backed by the Redis instance at addr,
+// retaining up to size points per user.
+func NewRedisStore(addr string, size int) *RedisStore {
+	return &RedisStore{
+		client: redis.NewClient(&redis.Options{Addr: addr}),
+		size:   int64(size),
+	}
+}
+
+func (s *RedisStore) Append(room, username string, loc LocationData) error {
+	ctx := context.Background()
+	key := trackKey(room, username)
+
+	data, err := json.Marshal(loc)
+	if err != nil {
+		return fmt.Errorf("marshaling location: %w", err)
+	}
+
+	if err := s.client.RPush(ctx, key, data).Err(); err != nil {
+		return fmt.Errorf("appending to redis list %s: %w", key, err)
+	}
+	if s.size > 0 {
+		if err := s.client.LTrim(ctx, key, -s.size, -1).Err(); err != nil {
+			return fmt.Errorf("trimming redis list %s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+func (s *RedisStore) History(room, username string, since int64) ([]LocationData, error) {
+	ctx := context.Background()
+	key := trackKey(room, username)
+
+	values, err := s.client.LRange(ctx, key, 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("reading redis list %s: %w", key, err)
+	}
+
+	track := make([]LocationData, 0, len(values))
+	for _, v := range values {
+		var loc LocationData
+		if err := json.Unmarshal([]byte(v), &loc); err != nil {
+			continue
+		}
+		track = append(track, loc)
+	}
+	return filterSince(track, since), nil
+}